@@ -0,0 +1,201 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+/*
+#cgo LDFLAGS: -lX11 -lXss
+#include <X11/Xlib.h>
+#include <X11/extensions/saver.h>
+#include <X11/extensions/scrnsaver.h>
+*/
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+// Backend is the interface through which a Client obtains idle-time
+// (and, where possible, screen-saver countdown/activity) information.
+// Query is called with the owning Client's mutex held, so a Backend
+// doesn't need to do its own locking around a display connection it
+// opened for itself.
+//
+// The package provides three implementations -- NewXSSBackend,
+// NewXSyncIdleTimerBackend and NewXI2RawInputBackend -- in the order
+// NewClient probes them.
+type Backend interface {
+	// Query reports the current idle time and, if the backend can
+	// determine it, screen-saver state.
+	Query() (Info, error)
+	// Close releases any resources (display connections, goroutines)
+	// held by the backend.
+	Close() error
+}
+
+// dispBackend is implemented by backends that hold their own dedicated
+// display connection (all three built-in ones do). newClientFromOpener and
+// Reconnect use it to extend IO-error recovery -- see registerClient -- to
+// that connection too, not just the Client's own cl.disp.
+type dispBackend interface {
+	Backend
+	backendDisplay() *C.Display
+}
+
+// xssBackend is the original Backend, implemented directly on top of
+// XScreenSaverQueryInfo. It is the only backend that can report Enabled,
+// Active, Kind, Countdown and ActiveTime; the others only ever report
+// IdleTime.
+type xssBackend struct {
+	disp *C.Display
+	info *C.XScreenSaverInfo
+}
+
+// NewXSSBackend opens its own connection to the named X display (as
+// understood by XOpenDisplay; pass "" for the default, $DISPLAY) and
+// returns a Backend backed by the XSS extension's XScreenSaverQueryInfo,
+// or an error if the extension isn't active.
+func NewXSSBackend(name string) (Backend, error) {
+	disp, err := openDisplay(name)
+	if err != nil {
+		return nil, err
+	}
+	var base, errbase C.int
+	if C.XScreenSaverQueryExtension(disp, &base, &errbase) == 0 {
+		C.XCloseDisplay(disp)
+		return nil, ErrNoExtension
+	}
+	return &xssBackend{
+		disp: disp,
+		info: C.XScreenSaverAllocInfo(),
+	}, nil
+}
+
+func (b *xssBackend) Query() (i Info, err error) {
+	root := C.XDefaultRootWindow(b.disp)
+	if queryScreenSaverInfo(b.disp, root, b.info) == 0 {
+		err = fmt.Errorf("xss: error querying XSS extension")
+		return
+	}
+	i.IdleTime = time.Duration(b.info.idle) * time.Millisecond
+	switch b.info.state {
+	case C.ScreenSaverOn:
+		i.Enabled = true
+		i.Active = true
+		i.ActiveTime = time.Duration(b.info.til_or_since) * time.Millisecond
+	case C.ScreenSaverOff:
+		i.Enabled = true
+		i.Active = false
+		i.Countdown = time.Duration(b.info.til_or_since) * time.Millisecond
+	case C.ScreenSaverDisabled:
+		i.Enabled = false
+		i.Active = false
+	}
+	switch b.info.kind {
+	case C.ScreenSaverBlanked:
+		i.Kind = Blanked
+	case C.ScreenSaverInternal:
+		i.Kind = Internal
+	case C.ScreenSaverExternal:
+		i.Kind = External
+	}
+	return
+}
+
+func (b *xssBackend) Close() error {
+	C.XFree(unsafe.Pointer(b.info))
+	C.XCloseDisplay(b.disp)
+	return nil
+}
+
+func (b *xssBackend) backendDisplay() *C.Display {
+	return b.disp
+}
+
+// probeBackend tries each Backend implementation in turn, in the order
+// they're most likely to give an accurate answer on a modern desktop, all
+// against the named display (see NewClientForDisplay).
+func probeBackend(name string) (Backend, error) {
+	if b, err := NewXSSBackend(name); err == nil {
+		return b, nil
+	}
+	if b, err := NewXSyncIdleTimerBackend(name); err == nil {
+		return b, nil
+	}
+	if b, err := NewXI2RawInputBackend(name); err == nil {
+		return b, nil
+	}
+	return nil, ErrNoExtension
+}
+
+// NewClient creates a persistent, thread-safe connection to the default
+// X display (the one named by $DISPLAY), probing for the best available
+// idle-time backend. Use NewClientForDisplay to target a different
+// display, or NewClientWithBackend to force a specific backend.
+func NewClient() (*Client, error) {
+	return NewClientForDisplay("")
+}
+
+// NewClientWithBackend creates a Client that forces the use of a specific
+// idle-time Backend rather than probing for one. The Client takes
+// ownership of backend, Close-ing it when the Client is garbage
+// collected; Reconnect keeps using the same backend.
+func NewClientWithBackend(backend Backend) (*Client, error) {
+	return newClientFromOpener(func() (*C.Display, Backend, error) {
+		disp := C.XOpenDisplay(nil)
+		if disp == nil {
+			return nil, nil, ErrNoDisplay
+		}
+		return disp, backend, nil
+	})
+}
+
+// opener (re)establishes a Client's display connection and Backend; it's
+// captured by each public constructor and invoked again by Reconnect.
+type opener func() (*C.Display, Backend, error)
+
+func newClientFromOpener(open opener) (*Client, error) {
+	installErrorHandler()
+
+	disp, backend, err := open()
+	if err != nil {
+		return nil, err
+	}
+	cl := &Client{
+		disp:    disp,
+		backend: backend,
+		open:    open,
+		errCh:   make(chan error, 4),
+	}
+	registerClient(cl)
+	runtime.SetFinalizer(cl, func(cl *Client) {
+		unregisterClient(cl)
+		cl.backend.Close()
+	})
+	return cl, nil
+}
+
+// Query queries the Client's Backend for idle-time and screen-saver
+// information.
+func (cl *Client) Query() (i Info, err error) {
+	if cl.disp == nil {
+		panic("Client instances must be created with NewClient.")
+	}
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	return cl.backend.Query()
+}
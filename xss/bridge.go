@@ -0,0 +1,75 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+/*
+#cgo LDFLAGS: -lX11
+#include <X11/Xlib.h>
+*/
+import "C"
+import "context"
+
+// forceScreenSaver invokes XForceScreenSaver on cl's display with the
+// given mode (ScreenSaverActive or ScreenSaverReset).
+func (cl *Client) forceScreenSaver(mode C.int) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	C.XForceScreenSaver(cl.disp, mode)
+	C.XFlush(cl.disp)
+}
+
+// Bridge keeps one or more destination displays' blanking state in
+// lockstep with a source display: whenever src's screen saver activates
+// or deactivates, the same transition is forced on every dst via
+// XForceScreenSaver. This is useful for keeping a secondary or virtual
+// display (VNC, xpra, a remote seat) blanking in sync with the display a
+// user is actually watching.
+//
+// Bridge subscribes to src in the background; call the returned stop
+// function to end the bridge and release that subscription.
+func Bridge(src *Client, dsts ...*Client) (stop func(), err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := src.Subscribe(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range events {
+			var mode C.int
+			switch ev.State {
+			case StateOn:
+				mode = C.ScreenSaverActive
+			case StateOff:
+				mode = C.ScreenSaverReset
+			default:
+				continue
+			}
+			for _, dst := range dsts {
+				dst.forceScreenSaver(mode)
+			}
+		}
+	}()
+
+	stop = func() {
+		cancel()
+		<-done
+	}
+	return stop, nil
+}
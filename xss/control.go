@@ -0,0 +1,175 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+/*
+#cgo LDFLAGS: -lX11
+#include <X11/Xlib.h>
+#include <X11/Xatom.h>
+#include <stdlib.h>
+
+static void setClientMessageLong(XClientMessageEvent *ev, int idx, long val) {
+	ev->data.l[idx] = val;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// sendScreensaverCommand sends a ClientMessage of type "SCREENSAVER",
+// carrying the atom named cmd, to the current owner of the "SCREENSAVER"
+// selection -- the same mechanism the xscreensaver-command binary uses
+// to drive a running xscreensaver daemon.
+func (cl *Client) sendScreensaverCommand(cmd string) error {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	selName := C.CString("SCREENSAVER")
+	defer C.free(unsafe.Pointer(selName))
+	selAtom := C.XInternAtom(cl.disp, selName, C.False)
+
+	owner := C.XGetSelectionOwner(cl.disp, selAtom)
+	if owner == C.None {
+		return fmt.Errorf("xss: no SCREENSAVER selection owner; is a screen-saver daemon running?")
+	}
+
+	cmdName := C.CString(cmd)
+	defer C.free(unsafe.Pointer(cmdName))
+	cmdAtom := C.XInternAtom(cl.disp, cmdName, C.False)
+
+	var ev C.XClientMessageEvent
+	ev._type = C.ClientMessage
+	ev.window = owner
+	ev.message_type = selAtom
+	ev.format = 32
+	C.setClientMessageLong(&ev, 0, C.long(cmdAtom))
+
+	if C.XSendEvent(cl.disp, owner, C.False, 0, (*C.XEvent)(unsafe.Pointer(&ev))) == 0 {
+		return fmt.Errorf("xss: could not send %s to the screen-saver daemon", cmd)
+	}
+	C.XFlush(cl.disp)
+	return nil
+}
+
+// Activate requests that the screen saver activate immediately, as if
+// the idle timeout had elapsed.
+func (cl *Client) Activate() error {
+	return cl.sendScreensaverCommand("ACTIVATE")
+}
+
+// Deactivate requests that an active screen saver deactivate immediately
+// and reset the idle timer, as if the user had just provided input.
+func (cl *Client) Deactivate() error {
+	return cl.sendScreensaverCommand("DEACTIVATE")
+}
+
+// Lock requests that the screen saver activate and lock the screen
+// immediately.
+func (cl *Client) Lock() error {
+	return cl.sendScreensaverCommand("LOCK")
+}
+
+// Cycle requests that an active screen saver switch to its next display
+// immediately.
+func (cl *Client) Cycle() error {
+	return cl.sendScreensaverCommand("CYCLE")
+}
+
+// Exit requests that the screen-saver daemon terminate.
+func (cl *Client) Exit() error {
+	return cl.sendScreensaverCommand("EXIT")
+}
+
+// getWindowStringProperty reads a property of a textual (STRING or
+// similar 8-bit) type from win, returning its raw bytes and whether it
+// was present at all.
+func getWindowStringProperty(disp *C.Display, win C.Window, name string) ([]byte, bool) {
+	propName := C.CString(name)
+	defer C.free(unsafe.Pointer(propName))
+	prop := C.XInternAtom(disp, propName, C.False)
+
+	var actualType C.Atom
+	var actualFormat C.int
+	var nItems, bytesAfter C.ulong
+	var data *C.uchar
+
+	status := C.XGetWindowProperty(
+		disp, win, prop,
+		0, 1024, C.False, C.AnyPropertyType,
+		&actualType, &actualFormat, &nItems, &bytesAfter,
+		&data,
+	)
+	if status != C.Success || data == nil {
+		return nil, false
+	}
+	defer C.XFree(unsafe.Pointer(data))
+	if nItems == 0 {
+		return nil, false
+	}
+	return C.GoBytes(unsafe.Pointer(data), C.int(nItems)), true
+}
+
+// ScreensaverDaemon reports the name and version of the screen-saver
+// daemon currently owning the "SCREENSAVER" selection -- the same window
+// sendScreensaverCommand sends control messages to. ok is false if
+// nothing owns the selection, meaning no compatible daemon is running.
+//
+// xscreensaver identifies itself with a "_SCREENSAVER_VERSION" property
+// on its selection-owner window; daemons that only implement the older
+// "_SCREENSAVER_STATUS" property (e.g. xlockmore, light-locker) are
+// reported with an empty version, since they don't advertise one.
+func (cl *Client) ScreensaverDaemon() (name string, version string, ok bool) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	selName := C.CString("SCREENSAVER")
+	defer C.free(unsafe.Pointer(selName))
+	selAtom := C.XInternAtom(cl.disp, selName, C.False)
+
+	owner := C.XGetSelectionOwner(cl.disp, selAtom)
+	if owner == C.None {
+		return "", "", false
+	}
+
+	if raw, ok := getWindowStringProperty(cl.disp, owner, "_SCREENSAVER_VERSION"); ok {
+		return "xscreensaver", parseScreensaverVersion(raw), true
+	}
+
+	// No version property, but something still owns the selection and
+	// implements at least the older "_SCREENSAVER_STATUS" protocol
+	// (xlockmore, light-locker) rather than xscreensaver's richer one. We
+	// can't tell which one, so report it as unidentified rather than
+	// guessing "xscreensaver".
+	if _, ok := getWindowStringProperty(cl.disp, owner, "_SCREENSAVER_STATUS"); ok {
+		return "unknown", "", true
+	}
+
+	// The selection has an owner but neither property -- a daemon
+	// speaking only a subset of the protocol. Report it as present but
+	// unidentified rather than claiming no daemon is running.
+	return "unknown", "", true
+}
+
+// parseScreensaverVersion extracts the version string from the raw bytes of
+// an xscreensaver "_SCREENSAVER_VERSION" property: one or more NUL-separated
+// fields, the first of which is the version.
+func parseScreensaverVersion(raw []byte) string {
+	fields := strings.Split(strings.TrimRight(string(raw), "\x00"), "\x00")
+	return fields[0]
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+import "testing"
+
+func TestParseScreensaverVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{"single field", []byte("5.45"), "5.45"},
+		{"NUL terminated", []byte("5.45\x00"), "5.45"},
+		{"multiple fields", []byte("5.45\x00Jamie Zawinski\x00"), "5.45"},
+		{"empty", []byte(""), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseScreensaverVersion(tt.raw); got != tt.want {
+				t.Errorf("parseScreensaverVersion(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
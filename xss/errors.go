@@ -0,0 +1,280 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+/*
+#include <X11/Xlib.h>
+#include <X11/extensions/saver.h>
+#include <X11/extensions/scrnsaver.h>
+#include <setjmp.h>
+#include <stdlib.h>
+
+extern int goXErrorHandler(Display *disp, XErrorEvent *ev);
+extern void goXIOErrorExitHandler(Display *disp);
+
+static int errorHandlerTrampoline(Display *disp, XErrorEvent *ev) {
+	return goXErrorHandler(disp, ev);
+}
+
+static void installErrorHandlerTrampoline(void) {
+	XSetErrorHandler(errorHandlerTrampoline);
+}
+
+// ioRecoveryBuf/ioRecoveryArmed give guardedXScreenSaverQueryInfo (and any
+// future guarded call added here) a thread-local escape hatch from a
+// fatal IO error. Xlib guarantees a display's XIOErrorExitHandler runs
+// synchronously, on the same OS thread and within the same call that
+// discovered the error, so a longjmp back to a setjmp placed immediately
+// around that same call -- without ever having returned up into Go in
+// between -- is safe. Recovering any further up the stack wouldn't be:
+// the jump would unwind through Go frames that Xlib and the C runtime
+// don't know how to unwind. That's why every guarded call lives here,
+// next to the jmp_buf it shares, instead of being scattered across the
+// files that would otherwise call Xlib directly.
+static __thread jmp_buf ioRecoveryBuf;
+static __thread int ioRecoveryArmed;
+
+static void ioErrorExitTrampoline(Display *disp, void *user_data) {
+	goXIOErrorExitHandler(disp);
+	if (ioRecoveryArmed) {
+		ioRecoveryArmed = 0;
+		longjmp(ioRecoveryBuf, 1);
+	}
+	// No guarded call was in flight on this thread to catch the error.
+	// XIOErrorExitHandler must not return, so exit the way Xlib's
+	// default handler would have.
+	exit(1);
+}
+
+static void installIOErrorExitHandlerTrampoline(Display *disp) {
+	XSetIOErrorExitHandler(disp, ioErrorExitTrampoline, NULL);
+}
+
+// guardedXScreenSaverQueryInfo calls XScreenSaverQueryInfo, recovering
+// instead of letting a fatal IO error on disp kill the process. It
+// returns 0 -- the same result XScreenSaverQueryInfo gives for any other
+// failure -- if the error handler had to step in; the real reason
+// (ErrDisplayGone) is available afterward via Client.LastError.
+static Status guardedXScreenSaverQueryInfo(Display *disp, Drawable root, XScreenSaverInfo *info) {
+	if (setjmp(ioRecoveryBuf)) {
+		ioRecoveryArmed = 0;
+		return 0;
+	}
+	ioRecoveryArmed = 1;
+	Status st = XScreenSaverQueryInfo(disp, root, info);
+	ioRecoveryArmed = 0;
+	return st;
+}
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Sentinel errors distinguish the ways a Client can fail to talk to the
+// X server, so callers can decide whether to retry, Reconnect, or give
+// up.
+var (
+	// No X display could be opened at all (bad $DISPLAY, no X running).
+	ErrNoDisplay = errors.New("xss: could not open X display")
+	// A display was opened, but no usable idle-time Backend was found on it.
+	ErrNoExtension = errors.New("xss: no usable idle-time backend available")
+	// The display connection has been lost, e.g. because Xorg restarted
+	// or the session was suspended and resumed. Call Client.Reconnect.
+	ErrDisplayGone = errors.New("xss: display connection is gone")
+	// The X server reported a protocol error that's likely to clear up
+	// on retry (e.g. a BadDrawable for a window that's mid-teardown).
+	ErrTransient = errors.New("xss: transient X server error")
+)
+
+// clientRegistry maps each display connection a Client relies on -- cl.disp
+// itself, and separately its Backend's own connection, if it has one (see
+// dispBackend) -- back to the Client, so the process-wide X error handler
+// installed by installErrorHandler, and the per-display IO error exit
+// handler installed for each by registerClient, can funnel an error on
+// either connection to the right place.
+var (
+	clientRegistryMu sync.Mutex
+	clientRegistry   = map[*C.Display]*Client{}
+)
+
+// registerClient records cl under its display connection, and under its
+// Backend's own connection if it has one, and arms each connection's fatal
+// IO error handling (see ioErrorExitTrampoline), so an IO error on either
+// connection is recorded as ErrDisplayGone for cl. The process survives
+// such an error only when it strikes inside a guarded call (see
+// queryScreenSaverInfo); anywhere else the handler still exits the process
+// after recording it. Registering the Backend connection matters because
+// Client.Query runs entirely on it, not on cl.disp.
+func registerClient(cl *Client) {
+	registerDisplay(cl.disp, cl)
+	if db, ok := cl.backend.(dispBackend); ok {
+		if disp := db.backendDisplay(); disp != nil && disp != cl.disp {
+			registerDisplay(disp, cl)
+		}
+	}
+}
+
+func registerDisplay(disp *C.Display, cl *Client) {
+	clientRegistryMu.Lock()
+	clientRegistry[disp] = cl
+	clientRegistryMu.Unlock()
+	C.installIOErrorExitHandlerTrampoline(disp)
+}
+
+func unregisterClient(cl *Client) {
+	unregisterDisplay(cl.disp)
+	if db, ok := cl.backend.(dispBackend); ok {
+		if disp := db.backendDisplay(); disp != nil && disp != cl.disp {
+			unregisterDisplay(disp)
+		}
+	}
+}
+
+func unregisterDisplay(disp *C.Display) {
+	clientRegistryMu.Lock()
+	defer clientRegistryMu.Unlock()
+	delete(clientRegistry, disp)
+}
+
+// recordError stashes err as cl's last error and, if anyone is listening,
+// delivers it on cl.Errors() without blocking.
+func (cl *Client) recordError(err error) {
+	cl.errMutex.Lock()
+	cl.lastErr = err
+	cl.errMutex.Unlock()
+
+	select {
+	case cl.errCh <- err:
+	default:
+	}
+}
+
+// LastError returns the most recent error recorded for cl -- an
+// ErrTransient protocol error or ErrDisplayGone -- or nil if none has
+// occurred since the Client was created or last Reconnected.
+func (cl *Client) LastError() error {
+	cl.errMutex.Lock()
+	defer cl.errMutex.Unlock()
+	return cl.lastErr
+}
+
+// Errors returns a channel on which the errors recorded for cl's display
+// connections (ErrTransient protocol errors and ErrDisplayGone) are
+// delivered.
+// The channel is buffered and lossy: if nothing reads from it fast
+// enough, later errors overwrite LastError but aren't queued here.
+func (cl *Client) Errors() <-chan error {
+	return cl.errCh
+}
+
+// Reconnect closes cl's current display connection and Backend and
+// re-establishes both the same way cl was originally created (by
+// NewClient, NewClientForDisplay, or NewClientWithBackend). A Client made
+// by NewClientWithBackend keeps the Backend it was given: only its own
+// display connection is closed and reopened. Long-running
+// callers should call this after ErrDisplayGone -- or after Query starts
+// returning errors following a suspend/resume or an Xorg restart -- to
+// recover without restarting the process.
+func (cl *Client) Reconnect() error {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	if cl.open == nil {
+		return fmt.Errorf("xss: Client has no connection to reconnect")
+	}
+
+	newDisp, newBackend, err := cl.open()
+	if err != nil {
+		return err
+	}
+
+	unregisterClient(cl)
+	if cl.backend != nil && cl.backend != newBackend {
+		cl.backend.Close()
+	}
+	if cl.disp != nil {
+		C.XCloseDisplay(cl.disp)
+	}
+
+	cl.disp = newDisp
+	cl.backend = newBackend
+	registerClient(cl)
+
+	cl.errMutex.Lock()
+	cl.lastErr = nil
+	cl.errMutex.Unlock()
+	return nil
+}
+
+// installErrorHandler installs errorHandlerTrampoline as the process's
+// XErrorHandler exactly once. Xlib only supports a single, global
+// protocol error handler (unlike Backend connections, which are all
+// independent), so every Client in the process shares it; the handler
+// uses clientRegistry to route each error back to the Client whose
+// display it occurred on.
+//
+// This replaces Xlib's default handler, which prints the error and
+// carries on, with one that instead stores it where Client.LastError and
+// Client.Errors can see it -- the difference matters for long-running
+// daemons that want to notice and react to transient errors rather than
+// have them silently logged to stderr.
+var installErrorHandlerOnce sync.Once
+
+func installErrorHandler() {
+	installErrorHandlerOnce.Do(func() {
+		C.installErrorHandlerTrampoline()
+	})
+}
+
+//export goXErrorHandler
+func goXErrorHandler(disp *C.Display, ev *C.XErrorEvent) C.int {
+	clientRegistryMu.Lock()
+	cl, ok := clientRegistry[disp]
+	clientRegistryMu.Unlock()
+	if !ok {
+		return 0
+	}
+
+	var buf [256]C.char
+	C.XGetErrorText(disp, C.int(ev.error_code), &buf[0], C.int(len(buf)))
+	cl.recordError(fmt.Errorf("%w: %s", ErrTransient, C.GoString(&buf[0])))
+	return 0
+}
+
+//export goXIOErrorExitHandler
+func goXIOErrorExitHandler(disp *C.Display) {
+	clientRegistryMu.Lock()
+	cl, ok := clientRegistry[disp]
+	clientRegistryMu.Unlock()
+	if !ok {
+		return
+	}
+	cl.recordError(ErrDisplayGone)
+}
+
+// queryScreenSaverInfo wraps XScreenSaverQueryInfo so a fatal IO error on
+// disp (e.g. Xorg restarting, or the connection dying in a
+// suspend/resume) surfaces as ErrDisplayGone via Client.LastError instead
+// of taking the whole process down with it. xssBackend.Query and
+// ScreenClient.Query -- the paths Reconnect's doc comment points callers
+// at -- go through this instead of calling XScreenSaverQueryInfo
+// directly.
+func queryScreenSaverInfo(disp *C.Display, root C.Window, info *C.XScreenSaverInfo) C.Status {
+	return C.guardedXScreenSaverQueryInfo(disp, C.Drawable(root), info)
+}
@@ -0,0 +1,365 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+/*
+#cgo LDFLAGS: -lX11 -lXss
+#include <X11/Xlib.h>
+#include <X11/extensions/saver.h>
+#include <X11/extensions/scrnsaver.h>
+#include <poll.h>
+#include <errno.h>
+
+// waitForExternalEvent mirrors subscribe.go's waitForEvent for the
+// dedicated display connection RegisterExternal uses to pump
+// ScreenSaverNotify and Expose events.
+static int waitForExternalEvent(Display *disp, int cancelfd) {
+	int xfd = ConnectionNumber(disp);
+	struct pollfd fds[2];
+	fds[0].fd = xfd;
+	fds[1].fd = cancelfd;
+	while (XPending(disp) == 0) {
+		fds[0].events = POLLIN;
+		fds[0].revents = 0;
+		fds[1].events = POLLIN;
+		fds[1].revents = 0;
+		int n = poll(fds, 2, -1);
+		if (n < 0) {
+			if (errno == EINTR) {
+				continue;
+			}
+			return -1;
+		}
+		if (fds[1].revents & POLLIN) {
+			return 0;
+		}
+	}
+	return 1;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+// WindowClass selects the X window class used for an External screen
+// saver window.
+type WindowClass int
+
+const (
+	// A normal, paintable window.
+	InputOutput WindowClass = iota
+	// An invisible window that can still receive events.
+	InputOnly
+)
+
+// Pixmap, Cursor, and Colormap identify existing X server resources by
+// their XID, for callers that want to pass one of their own resources
+// into ExternalOpts.
+type Pixmap uint64
+type Cursor uint64
+type Colormap uint64
+
+// ExternalOpts configures the window RegisterExternal creates.
+type ExternalOpts struct {
+	// Width and Height set the size of the screen-saver window. Zero
+	// means to use the root window's full size.
+	Width, Height uint32
+	// Class selects an InputOutput (paintable) or InputOnly window.
+	// The zero value is InputOutput.
+	Class WindowClass
+	// BackgroundPixel, if non-nil, sets the window's background color.
+	// It is ignored if BackgroundPixmap is also set.
+	BackgroundPixel *uint64
+	// BackgroundPixmap, if non-zero, sets the window's background tile.
+	BackgroundPixmap Pixmap
+	// Cursor, if non-zero, sets the cursor shown over the window.
+	Cursor Cursor
+	// Colormap, if non-zero, installs a non-default colormap for the
+	// window.
+	Colormap Colormap
+}
+
+// ExternalEventKind identifies the kind of ExternalEvent delivered by
+// ExternalWindow.Events.
+type ExternalEventKind int
+
+const (
+	// The screen saver transitioned, per Notify.
+	ExternalNotify ExternalEventKind = iota
+	// Part of the window needs to be repainted, per the Expose* fields.
+	ExternalExpose
+)
+
+// ExternalEvent is delivered on ExternalWindow.Events.
+type ExternalEvent struct {
+	Kind ExternalEventKind
+
+	// Notify is populated when Kind is ExternalNotify.
+	Notify Event
+
+	// ExposeX, ExposeY, ExposeWidth and ExposeHeight describe the
+	// rectangle that needs repainting when Kind is ExternalExpose.
+	ExposeX, ExposeY          int
+	ExposeWidth, ExposeHeight int
+}
+
+// ExternalWindow is the screen-saver window created by
+// Client.RegisterExternal. The server creates and destroys the
+// underlying X window as the screen saver activates and deactivates, so
+// Window() may return 0 between activations.
+type ExternalWindow struct {
+	cl     *Client
+	root   C.Window
+	window C.Window
+}
+
+// Window returns the XID of the window to paint into, or 0 if the screen
+// saver isn't currently active (and so the server hasn't created it
+// yet).
+func (ew *ExternalWindow) Window() uint64 {
+	ew.cl.extMutex.Lock()
+	defer ew.cl.extMutex.Unlock()
+	return uint64(ew.window)
+}
+
+// Events returns the channel on which ScreenSaverNotify transitions and
+// Expose events for ew's window are delivered. It is closed when the
+// Client's external-screen-saver registration is torn down with
+// UnregisterExternal.
+func (ew *ExternalWindow) Events() <-chan ExternalEvent {
+	return ew.cl.extEvents()
+}
+
+// RegisterExternal registers the calling client as the screen saver's
+// External implementation via XScreenSaverSetAttributes, so the server
+// creates a window for us to paint into whenever the screen saver
+// activates (Kind == External), instead of blanking the display itself
+// or handing the job to another client. It requires XSS >= 1.1; check
+// with QueryVersion first if that isn't guaranteed.
+func (cl *Client) RegisterExternal(opts ExternalOpts) (*ExternalWindow, error) {
+	cl.mutex.Lock()
+
+	root := C.XDefaultRootWindow(cl.disp)
+	screen := C.XDefaultScreenOfDisplay(cl.disp)
+	width := C.uint(opts.Width)
+	if width == 0 {
+		width = C.uint(C.XWidthOfScreen(screen))
+	}
+	height := C.uint(opts.Height)
+	if height == 0 {
+		height = C.uint(C.XHeightOfScreen(screen))
+	}
+	class := C.uint(C.InputOutput)
+	if opts.Class == InputOnly {
+		class = C.InputOnly
+	}
+
+	var attrs C.XSetWindowAttributes
+	var valuemask C.ulong
+	attrs.event_mask = C.ScreenSaverNotifyMask | C.ExposureMask
+	valuemask |= C.CWEventMask
+	if opts.BackgroundPixmap != 0 {
+		attrs.background_pixmap = C.ulong(opts.BackgroundPixmap)
+		valuemask |= C.CWBackPixmap
+	} else if opts.BackgroundPixel != nil {
+		attrs.background_pixel = C.ulong(*opts.BackgroundPixel)
+		valuemask |= C.CWBackPixel
+	}
+	if opts.Cursor != 0 {
+		attrs.cursor = C.ulong(opts.Cursor)
+		valuemask |= C.CWCursor
+	}
+	if opts.Colormap != 0 {
+		attrs.colormap = C.ulong(opts.Colormap)
+		valuemask |= C.CWColormap
+	}
+
+	cl.errMutex.Lock()
+	prevErr := cl.lastErr
+	cl.errMutex.Unlock()
+
+	C.XScreenSaverSetAttributes(
+		cl.disp, root,
+		0, 0, width, height, 0, C.CopyFromParent, class,
+		nil, valuemask, &attrs,
+	)
+	// XScreenSaverSetAttributes only reports whether the request was
+	// well-formed, not whether the server accepted it (e.g. BadAccess if
+	// another client already owns the External saver). Round-trip with
+	// XSync so any such protocol error reaches the process-wide error
+	// handler synchronously, and surface it here instead of only via
+	// Client.Errors at some later, unrelated point.
+	C.XSync(cl.disp, C.False)
+
+	cl.errMutex.Lock()
+	newErr := cl.lastErr
+	cl.errMutex.Unlock()
+	cl.mutex.Unlock()
+
+	if newErr != nil && newErr != prevErr {
+		return nil, fmt.Errorf("xss: could not register as external screen saver: %w", newErr)
+	}
+
+	ew := &ExternalWindow{cl: cl, root: root}
+
+	if err := cl.startExternalPump(ew); err != nil {
+		cl.UnregisterExternal()
+		return nil, err
+	}
+	return ew, nil
+}
+
+// startExternalPump opens a second connection to cl's own display (so it
+// doesn't contend with Query's or Subscribe's calls) selecting
+// ScreenSaverNotify on root, and decodes/fans out Notify and, once the
+// server creates the saver window, Expose events for it.
+func (cl *Client) startExternalPump(ew *ExternalWindow) error {
+	cl.extMutex.Lock()
+	defer cl.extMutex.Unlock()
+
+	disp := C.XOpenDisplay(C.XDisplayString(cl.disp))
+	if disp == nil {
+		return ErrNoDisplay
+	}
+	var base, errbase C.int
+	if C.XScreenSaverQueryExtension(disp, &base, &errbase) == 0 {
+		C.XCloseDisplay(disp)
+		return ErrNoExtension
+	}
+	C.XScreenSaverSelectInput(disp, ew.root, C.ScreenSaverNotifyMask)
+
+	cancelR, cancelW, err := os.Pipe()
+	if err != nil {
+		C.XCloseDisplay(disp)
+		return err
+	}
+
+	events := make(chan ExternalEvent, 16)
+
+	cl.ext = ew
+	cl.extDisp = disp
+	cl.extCancelR = cancelR
+	cl.extCancelW = cancelW
+	cl.extPumpDone = make(chan struct{})
+	cl.extEventsCh = events
+
+	go cl.runExternalPump(disp, base, cancelR, events, cl.extPumpDone)
+	return nil
+}
+
+func (cl *Client) runExternalPump(disp *C.Display, base C.int, cancelR *os.File, events chan ExternalEvent, done chan struct{}) {
+	defer close(done)
+	cancelFD := C.int(cancelR.Fd())
+
+	for {
+		ready := C.waitForExternalEvent(disp, cancelFD)
+		if ready <= 0 {
+			break
+		}
+		var xev C.XEvent
+		C.XNextEvent(disp, &xev)
+
+		any := (*C.XAnyEvent)(unsafe.Pointer(&xev))
+		switch {
+		case any._type == base+C.ScreenSaverNotify:
+			nev, ok := decodeNotifyEvent(&xev, base)
+			if !ok {
+				continue
+			}
+			cl.extMutex.Lock()
+			if cl.ext != nil {
+				if nev.State == StateOn {
+					cl.ext.window = (*C.XScreenSaverNotifyEvent)(unsafe.Pointer(&xev)).window
+					C.XSelectInput(disp, cl.ext.window, C.ExposureMask)
+				} else {
+					cl.ext.window = 0
+				}
+			}
+			cl.extMutex.Unlock()
+			select {
+			case events <- (ExternalEvent{Kind: ExternalNotify, Notify: nev}):
+			default:
+			}
+		case any._type == C.Expose:
+			eev := (*C.XExposeEvent)(unsafe.Pointer(&xev))
+			select {
+			case events <- (ExternalEvent{
+				Kind:         ExternalExpose,
+				ExposeX:      int(eev.x),
+				ExposeY:      int(eev.y),
+				ExposeWidth:  int(eev.width),
+				ExposeHeight: int(eev.height),
+			}):
+			default:
+			}
+		}
+	}
+
+	cl.extMutex.Lock()
+	C.XCloseDisplay(disp)
+	cl.extCancelR.Close()
+	cl.extCancelW.Close()
+	close(events)
+	cl.ext = nil
+	cl.extDisp = nil
+	cl.extEventsCh = nil
+	cl.extMutex.Unlock()
+}
+
+func (cl *Client) extEvents() <-chan ExternalEvent {
+	cl.extMutex.Lock()
+	defer cl.extMutex.Unlock()
+	return cl.extEventsCh
+}
+
+// UnregisterExternal reverses RegisterExternal via
+// XScreenSaverUnsetAttributes and stops delivering ExternalEvents.
+func (cl *Client) UnregisterExternal() error {
+	cl.mutex.Lock()
+	root := C.XDefaultRootWindow(cl.disp)
+	C.XScreenSaverUnsetAttributes(cl.disp, root)
+	C.XFlush(cl.disp)
+	cl.mutex.Unlock()
+
+	cl.extMutex.Lock()
+	cancelW := cl.extCancelW
+	done := cl.extPumpDone
+	cl.extMutex.Unlock()
+
+	if cancelW != nil {
+		cancelW.Write([]byte{0})
+		<-done
+	}
+	return nil
+}
+
+// QueryVersion reports the version of the XSS extension supported by the
+// server. External mode (RegisterExternal) requires major >= 1, minor >= 1.
+func (cl *Client) QueryVersion() (major, minor int, err error) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	var maj, min C.int
+	if C.XScreenSaverQueryVersion(cl.disp, &maj, &min) == 0 {
+		err = fmt.Errorf("xss: could not query XSS extension version")
+		return
+	}
+	return int(maj), int(min), nil
+}
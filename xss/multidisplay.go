@@ -0,0 +1,127 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+/*
+#cgo LDFLAGS: -lX11 -lXss
+#include <X11/Xlib.h>
+#include <X11/extensions/saver.h>
+#include <X11/extensions/scrnsaver.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// openDisplay opens a connection to the named X display, as understood by
+// XOpenDisplay (e.g. ":1", "vnc-host:0.0"), or the one named by $DISPLAY if
+// name is "".
+func openDisplay(name string) (*C.Display, error) {
+	var cName *C.char
+	if name != "" {
+		cName = C.CString(name)
+		defer C.free(unsafe.Pointer(cName))
+	}
+	disp := C.XOpenDisplay(cName)
+	if disp == nil {
+		return nil, fmt.Errorf("%w: %q", ErrNoDisplay, name)
+	}
+	return disp, nil
+}
+
+// NewClientForDisplay is like NewClient, but connects to the named X
+// display (as understood by XOpenDisplay -- e.g. ":1", "vnc-host:0.0")
+// instead of the one named by $DISPLAY. Pass "" for the default display.
+func NewClientForDisplay(name string) (*Client, error) {
+	return newClientFromOpener(func() (*C.Display, Backend, error) {
+		disp, err := openDisplay(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		backend, err := probeBackend(name)
+		if err != nil {
+			C.XCloseDisplay(disp)
+			return nil, nil, err
+		}
+		return disp, backend, nil
+	})
+}
+
+// ScreenClient targets a single screen of a Client's display, for
+// displays with more than one screen (e.g. `:0.0` and `:0.1`). Unlike
+// Client.Query, ScreenClient.Query always reads XScreenSaverQueryInfo
+// directly and doesn't go through the Client's Backend, since the
+// alternate backends don't have a notion of per-screen idle time.
+type ScreenClient struct {
+	cl   *Client
+	root C.Window
+}
+
+// Screens returns a ScreenClient for every screen of cl's display.
+func (cl *Client) Screens() []ScreenClient {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	n := C.XScreenCount(cl.disp)
+	screens := make([]ScreenClient, 0, int(n))
+	for i := C.int(0); i < n; i++ {
+		screens = append(screens, ScreenClient{
+			cl:   cl,
+			root: C.XRootWindow(cl.disp, i),
+		})
+	}
+	return screens
+}
+
+// Query queries the XSS extension for sc's screen.
+func (sc ScreenClient) Query() (i Info, err error) {
+	sc.cl.mutex.Lock()
+	defer sc.cl.mutex.Unlock()
+
+	info := C.XScreenSaverAllocInfo()
+	defer C.XFree(unsafe.Pointer(info))
+
+	if queryScreenSaverInfo(sc.cl.disp, sc.root, info) == 0 {
+		err = fmt.Errorf("xss: error querying XSS extension")
+		return
+	}
+	i.IdleTime = time.Duration(info.idle) * time.Millisecond
+	switch info.state {
+	case C.ScreenSaverOn:
+		i.Enabled = true
+		i.Active = true
+		i.ActiveTime = time.Duration(info.til_or_since) * time.Millisecond
+	case C.ScreenSaverOff:
+		i.Enabled = true
+		i.Active = false
+		i.Countdown = time.Duration(info.til_or_since) * time.Millisecond
+	case C.ScreenSaverDisabled:
+		i.Enabled = false
+		i.Active = false
+	}
+	switch info.kind {
+	case C.ScreenSaverBlanked:
+		i.Kind = Blanked
+	case C.ScreenSaverInternal:
+		i.Kind = Internal
+	case C.ScreenSaverExternal:
+		i.Kind = External
+	}
+	return
+}
@@ -0,0 +1,270 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+/*
+#cgo LDFLAGS: -lX11 -lXss
+#include <X11/Xlib.h>
+#include <X11/extensions/saver.h>
+#include <X11/extensions/scrnsaver.h>
+#include <poll.h>
+#include <errno.h>
+
+// waitForEvent blocks until either an XSS event is queued on disp or a
+// byte is written to cancelfd (the read end of a self-pipe). It returns 1
+// when an event is ready to be read with XNextEvent, 0 when cancelled,
+// and -1 on error.
+static int waitForEvent(Display *disp, int cancelfd) {
+	int xfd = ConnectionNumber(disp);
+	struct pollfd fds[2];
+	fds[0].fd = xfd;
+	fds[1].fd = cancelfd;
+	while (XPending(disp) == 0) {
+		fds[0].events = POLLIN;
+		fds[0].revents = 0;
+		fds[1].events = POLLIN;
+		fds[1].revents = 0;
+		int n = poll(fds, 2, -1);
+		if (n < 0) {
+			if (errno == EINTR) {
+				continue;
+			}
+			return -1;
+		}
+		if (fds[1].revents & POLLIN) {
+			return 0;
+		}
+	}
+	return 1;
+}
+*/
+import "C"
+
+import (
+	"context"
+	"os"
+	"unsafe"
+)
+
+// State describes the ScreenSaverNotify transition reported by an Event.
+type State int
+
+const (
+	// The screen saver became inactive.
+	StateOff State = iota
+	// The screen saver became active.
+	StateOn
+	// The screen saver, already active, cycled to its next display.
+	StateCycle
+	// The screen saver was disabled.
+	StateDisabled
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOff:
+		return "off"
+	case StateOn:
+		return "on"
+	case StateCycle:
+		return "cycle"
+	case StateDisabled:
+		return "disabled"
+	}
+	return "unknown"
+}
+
+// Event describes a single ScreenSaverNotify transition delivered by
+// Client.Subscribe.
+type Event struct {
+	// State specifies the transition that occurred.
+	State State
+	// Kind specifies which screen-saving strategy is in effect.
+	Kind Kind
+	// ForcedByClient specifies whether the transition was triggered by a
+	// client's XForceScreenSaver call rather than normal idle timeout.
+	ForcedByClient bool
+	// ServerTime is the X server timestamp (milliseconds since the
+	// server started, per the X protocol's Time type -- not Unix time)
+	// at which the transition occurred. It's only meaningful relative to
+	// other X timestamps from the same server.
+	ServerTime uint32
+}
+
+// subscription is the fan-out registration for a single Subscribe caller.
+type subscription struct {
+	ch chan Event
+}
+
+// startEventPump opens a second connection to cl's own display (so the
+// event thread's blocking XNextEvent calls don't contend with Query's
+// synchronous round trips on cl.disp), selects for ScreenSaverNotify, and
+// starts the goroutine that decodes and fans out events.
+func (cl *Client) startEventPump() error {
+	disp := C.XOpenDisplay(C.XDisplayString(cl.disp))
+	if disp == nil {
+		return ErrNoDisplay
+	}
+	var base, errbase C.int
+	if C.XScreenSaverQueryExtension(disp, &base, &errbase) == 0 {
+		C.XCloseDisplay(disp)
+		return ErrNoExtension
+	}
+	root := C.XDefaultRootWindow(disp)
+	C.XScreenSaverSelectInput(disp, root, C.ScreenSaverNotifyMask|C.ScreenSaverCycleMask)
+
+	cancelR, cancelW, err := os.Pipe()
+	if err != nil {
+		C.XCloseDisplay(disp)
+		return err
+	}
+
+	cl.subDisp = disp
+	cl.subEventBase = base
+	cl.subRoot = root
+	cl.subCancelR = cancelR
+	cl.subCancelW = cancelW
+	cl.subs = make(map[chan Event]struct{})
+	cl.subPumpDone = make(chan struct{})
+
+	go cl.runEventPump()
+	return nil
+}
+
+func (cl *Client) runEventPump() {
+	defer close(cl.subPumpDone)
+	cancelFD := C.int(cl.subCancelR.Fd())
+	for {
+		ready := C.waitForEvent(cl.subDisp, cancelFD)
+		if ready <= 0 {
+			break
+		}
+		var xev C.XEvent
+		C.XNextEvent(cl.subDisp, &xev)
+		ev, ok := decodeNotifyEvent(&xev, cl.subEventBase)
+		if !ok {
+			continue
+		}
+		cl.subMutex.Lock()
+		for ch := range cl.subs {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+		cl.subMutex.Unlock()
+	}
+
+	cl.subMutex.Lock()
+	C.XScreenSaverSelectInput(cl.subDisp, cl.subRoot, 0)
+	C.XCloseDisplay(cl.subDisp)
+	cl.subCancelR.Close()
+	cl.subCancelW.Close()
+	for ch := range cl.subs {
+		delete(cl.subs, ch)
+		close(ch)
+	}
+	cl.subDisp = nil
+	cl.subs = nil
+	cl.subMutex.Unlock()
+}
+
+func decodeNotifyEvent(xev *C.XEvent, base C.int) (Event, bool) {
+	nev := (*C.XScreenSaverNotifyEvent)(unsafe.Pointer(xev))
+	if nev._type != base+C.ScreenSaverNotify {
+		return Event{}, false
+	}
+	return Event{
+		State:          stateFromNotify(int(nev.state)),
+		Kind:           kindFromNotify(int(nev.kind)),
+		ForcedByClient: nev.forced != 0,
+		ServerTime:     uint32(nev.time),
+	}, true
+}
+
+// stateFromNotify maps an XScreenSaverNotifyEvent's state field -- one of
+// the ScreenSaverOff/On/Cycle/Disabled constants from
+// <X11/extensions/saver.h> -- to a State. It makes no Xlib calls, so it can
+// be unit tested without a live X server.
+func stateFromNotify(state int) State {
+	switch state {
+	case int(C.ScreenSaverOn):
+		return StateOn
+	case int(C.ScreenSaverCycle):
+		return StateCycle
+	case int(C.ScreenSaverDisabled):
+		return StateDisabled
+	}
+	return StateOff
+}
+
+// kindFromNotify maps an XScreenSaverNotifyEvent's kind field -- one of the
+// ScreenSaverBlanked/Internal/External constants from
+// <X11/extensions/saver.h> -- to a Kind.
+func kindFromNotify(kind int) Kind {
+	switch kind {
+	case int(C.ScreenSaverInternal):
+		return Internal
+	case int(C.ScreenSaverExternal):
+		return External
+	}
+	return Blanked
+}
+
+// Subscribe delivers Events whenever the screen saver transitions between
+// on, off, cycle, or disabled states. The returned channel is closed when
+// ctx is done. It buffers up to 8 Events; an Event that arrives while the
+// buffer is full is dropped for this subscriber only, so callers should
+// keep draining it until it closes.
+func (cl *Client) Subscribe(ctx context.Context) (<-chan Event, error) {
+	if cl.disp == nil {
+		panic("Client instances must be created with NewClient.")
+	}
+	cl.subMutex.Lock()
+	defer cl.subMutex.Unlock()
+
+	if cl.subs == nil {
+		if err := cl.startEventPump(); err != nil {
+			return nil, err
+		}
+	}
+
+	ch := make(chan Event, 8)
+	cl.subs[ch] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		cl.unsubscribe(ch)
+	}()
+
+	return ch, nil
+}
+
+func (cl *Client) unsubscribe(ch chan Event) {
+	cl.subMutex.Lock()
+	_, ok := cl.subs[ch]
+	if ok {
+		delete(cl.subs, ch)
+		close(ch)
+	}
+	empty := len(cl.subs) == 0
+	cl.subMutex.Unlock()
+
+	if empty {
+		cl.subCancelW.Write([]byte{0})
+		<-cl.subPumpDone
+	}
+}
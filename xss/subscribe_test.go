@@ -0,0 +1,86 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+import "testing"
+
+// Mirrors the ScreenSaverNotify state/kind constants from
+// <X11/extensions/saver.h>. They're part of the X ScreenSaverExt wire
+// protocol, not this package's ABI, so they're duplicated here rather than
+// pulled in via cgo -- which _test.go files can't import anyway.
+const (
+	xssOff      = 0
+	xssOn       = 1
+	xssCycle    = 2
+	xssDisabled = 3
+
+	xssBlanked  = 0
+	xssInternal = 1
+	xssExternal = 2
+)
+
+func TestStateString(t *testing.T) {
+	tests := []struct {
+		state State
+		want  string
+	}{
+		{StateOff, "off"},
+		{StateOn, "on"},
+		{StateCycle, "cycle"},
+		{StateDisabled, "disabled"},
+		{State(99), "unknown"},
+	}
+	for _, tt := range tests {
+		if got := tt.state.String(); got != tt.want {
+			t.Errorf("State(%d).String() = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestStateFromNotify(t *testing.T) {
+	tests := []struct {
+		raw  int
+		want State
+	}{
+		{xssOff, StateOff},
+		{xssOn, StateOn},
+		{xssCycle, StateCycle},
+		{xssDisabled, StateDisabled},
+		{99, StateOff},
+	}
+	for _, tt := range tests {
+		if got := stateFromNotify(tt.raw); got != tt.want {
+			t.Errorf("stateFromNotify(%d) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestKindFromNotify(t *testing.T) {
+	tests := []struct {
+		raw  int
+		want Kind
+	}{
+		{xssBlanked, Blanked},
+		{xssInternal, Internal},
+		{xssExternal, External},
+		{99, Blanked},
+	}
+	for _, tt := range tests {
+		if got := kindFromNotify(tt.raw); got != tt.want {
+			t.Errorf("kindFromNotify(%d) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
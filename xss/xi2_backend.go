@@ -0,0 +1,168 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+/*
+#cgo LDFLAGS: -lX11 -lXi
+#include <X11/Xlib.h>
+#include <X11/extensions/XInput2.h>
+#include <poll.h>
+#include <errno.h>
+
+static void setRawEventMask(unsigned char *mask) {
+	XISetMask(mask, XI_RawKeyPress);
+	XISetMask(mask, XI_RawMotion);
+}
+
+// waitForXI2Event blocks until either an event is queued on disp or a
+// byte is written to cancelfd. See subscribe.go's waitForEvent, which
+// this mirrors for a second, independent display connection.
+static int waitForXI2Event(Display *disp, int cancelfd) {
+	int xfd = ConnectionNumber(disp);
+	struct pollfd fds[2];
+	fds[0].fd = xfd;
+	fds[1].fd = cancelfd;
+	while (XPending(disp) == 0) {
+		fds[0].events = POLLIN;
+		fds[0].revents = 0;
+		fds[1].events = POLLIN;
+		fds[1].revents = 0;
+		int n = poll(fds, 2, -1);
+		if (n < 0) {
+			if (errno == EINTR) {
+				continue;
+			}
+			return -1;
+		}
+		if (fds[1].revents & POLLIN) {
+			return 0;
+		}
+	}
+	return 1;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// xi2RawInputBackend reports idle time by tracking raw XI_RawKeyPress and
+// XI_RawMotion events on a dedicated XInput2 connection. Raw events are
+// delivered regardless of which window has focus and aren't consumed by
+// client event filtering, so this stays accurate even when the XSS and
+// XSync idle counters don't (e.g. some VM/Xwayland configurations).
+type xi2RawInputBackend struct {
+	disp      *C.Display
+	lastInput int64 // unix nanoseconds, accessed atomically
+	cancelR   *os.File
+	cancelW   *os.File
+	pumpDone  chan struct{}
+}
+
+// NewXI2RawInputBackend opens a dedicated connection to the named X display
+// (as understood by XOpenDisplay; pass "" for the default, $DISPLAY),
+// selects XI_RawKeyPress and XI_RawMotion on the root window, and returns a
+// Backend that reports idle time as time since the last such event. It
+// requires XInput 2.0 or later.
+func NewXI2RawInputBackend(name string) (Backend, error) {
+	disp, err := openDisplay(name)
+	if err != nil {
+		return nil, err
+	}
+	major, minor := C.int(2), C.int(0)
+	if C.XIQueryVersion(disp, &major, &minor) != C.Success {
+		C.XCloseDisplay(disp)
+		return nil, fmt.Errorf("xss: XInput2 not available")
+	}
+
+	maskLen := C.int((C.XI_LASTEVENT + 7) / 8)
+	mask := make([]byte, maskLen)
+	C.setRawEventMask((*C.uchar)(unsafe.Pointer(&mask[0])))
+
+	evmask := C.XIEventMask{
+		deviceid: C.XIAllMasterDevices,
+		mask_len: maskLen,
+		mask:     (*C.uchar)(unsafe.Pointer(&mask[0])),
+	}
+	root := C.XDefaultRootWindow(disp)
+	if C.XISelectEvents(disp, root, &evmask, 1) != C.Success {
+		C.XCloseDisplay(disp)
+		return nil, fmt.Errorf("xss: could not select XInput2 raw events")
+	}
+
+	cancelR, cancelW, err := os.Pipe()
+	if err != nil {
+		C.XCloseDisplay(disp)
+		return nil, err
+	}
+
+	b := &xi2RawInputBackend{
+		disp:      disp,
+		lastInput: time.Now().UnixNano(),
+		cancelR:   cancelR,
+		cancelW:   cancelW,
+		pumpDone:  make(chan struct{}),
+	}
+	go b.pump()
+	return b, nil
+}
+
+func (b *xi2RawInputBackend) pump() {
+	defer close(b.pumpDone)
+	cancelFD := C.int(b.cancelR.Fd())
+	for {
+		ready := C.waitForXI2Event(b.disp, cancelFD)
+		if ready <= 0 {
+			break
+		}
+		var xev C.XEvent
+		C.XNextEvent(b.disp, &xev)
+		cookie := (*C.XGenericEventCookie)(unsafe.Pointer(&xev))
+		if cookie._type != C.GenericEvent {
+			continue
+		}
+		if C.XGetEventData(b.disp, cookie) == 0 {
+			continue
+		}
+		if cookie.evtype == C.XI_RawKeyPress || cookie.evtype == C.XI_RawMotion {
+			atomic.StoreInt64(&b.lastInput, time.Now().UnixNano())
+		}
+		C.XFreeEventData(b.disp, cookie)
+	}
+	b.cancelR.Close()
+	b.cancelW.Close()
+}
+
+func (b *xi2RawInputBackend) Query() (i Info, err error) {
+	last := atomic.LoadInt64(&b.lastInput)
+	i.IdleTime = time.Since(time.Unix(0, last))
+	return
+}
+
+func (b *xi2RawInputBackend) Close() error {
+	b.cancelW.Write([]byte{0})
+	<-b.pumpDone
+	C.XCloseDisplay(b.disp)
+	return nil
+}
+
+func (b *xi2RawInputBackend) backendDisplay() *C.Display {
+	return b.disp
+}
@@ -22,18 +22,12 @@ package xss
 #include <X11/Xlib.h>
 #include <X11/extensions/saver.h>
 #include <X11/extensions/scrnsaver.h>
-
-Status QueryInfo (Display *disp, XScreenSaverInfo *info) {
-	return XScreenSaverQueryInfo(disp, DefaultRootWindow(disp), info);
-}
 */
 import "C"
 import (
-	"fmt"
-	"runtime"
+	"os"
 	"sync"
 	"time"
-	"unsafe"
 )
 
 // Kind specifies one of three screen-saving strategies.
@@ -78,74 +72,38 @@ type Info struct {
 	IdleTime time.Duration
 }
 
+// Client is a persistent, thread-safe connection to an X display used to
+// query and control screen-saving behavior. Create one with NewClient or
+// NewClientWithBackend.
 type Client struct {
-	disp  *C.Display
-	info  *C.XScreenSaverInfo
-	mutex sync.Mutex
-}
+	disp    *C.Display
+	backend Backend
+	mutex   sync.Mutex
+	open    opener
 
-// NewClient creates a persistent, thread-safe connection to the XSS extension.
-func NewClient() (*Client, error) {
-	disp := C.XOpenDisplay(nil)
-	if disp == nil {
-		return nil, fmt.Errorf("Could not open X display.")
-	}
-	var base, errbase C.int
-	if C.XScreenSaverQueryExtension(
-		disp,
-		&base,
-		&errbase,
-	) == 0 {
-		return nil, fmt.Errorf("XSS extension not active.")
-	}
-	cl := &Client{
-		disp: disp,
-		info: C.XScreenSaverAllocInfo(),
-	}
-	runtime.SetFinalizer(cl, func(cl *Client) {
-		C.XFree(unsafe.Pointer(cl.info))
-	})
-	return cl, nil
-}
+	// X errors (ErrTransient, ErrDisplayGone) reported for disp or the
+	// Backend's own connection, recorded by the handlers in errors.go.
+	errMutex sync.Mutex
+	lastErr  error
+	errCh    chan error
 
-// Query queries the XSS extension.
-func (cl Client) Query() (i Info, err error) {
-	if cl.disp == nil {
-		panic("Client instances must be created with NewClient.")
-	}
-	var status C.Status
-	(func() {
-		cl.mutex.Lock()
-		defer cl.mutex.Unlock()
-		status = C.QueryInfo(cl.disp, cl.info)
-	})()
-	if status == 0 {
-		err = fmt.Errorf("Error querying XSS.")
-		return
-	}
-	i.IdleTime = time.Duration(cl.info.idle) * time.Millisecond
-	switch cl.info.state {
-	case C.ScreenSaverOn:
-		i.Enabled = true
-		i.Active = true
-		i.ActiveTime =
-			time.Duration(cl.info.til_or_since) * time.Millisecond
-	case C.ScreenSaverOff:
-		i.Enabled = true
-		i.Active = false
-		i.Countdown =
-			time.Duration(cl.info.til_or_since) * time.Millisecond
-	case C.ScreenSaverDisabled:
-		i.Enabled = false
-		i.Active = false
-	}
-	switch cl.info.kind {
-	case C.ScreenSaverBlanked:
-		i.Kind = Blanked
-	case C.ScreenSaverInternal:
-		i.Kind = Internal
-	case C.ScreenSaverExternal:
-		i.Kind = External
-	}
-	return
+	// Subscribe state. subDisp is a second, dedicated display connection
+	// used to pump events without contending with Query's calls on disp.
+	subMutex     sync.Mutex
+	subDisp      *C.Display
+	subEventBase C.int
+	subRoot      C.Window
+	subCancelR   *os.File
+	subCancelW   *os.File
+	subs         map[chan Event]struct{}
+	subPumpDone  chan struct{}
+
+	// RegisterExternal state, guarded by extMutex.
+	extMutex    sync.Mutex
+	ext         *ExternalWindow
+	extDisp     *C.Display
+	extCancelR  *os.File
+	extCancelW  *os.File
+	extPumpDone chan struct{}
+	extEventsCh chan ExternalEvent
 }
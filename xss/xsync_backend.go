@@ -0,0 +1,105 @@
+// Copyright 2019 Branen Salmon
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package xss
+
+/*
+#cgo LDFLAGS: -lX11 -lXext
+#include <X11/Xlib.h>
+#include <X11/extensions/sync.h>
+#include <stdlib.h>
+#include <string.h>
+
+// findIdletimeCounter scans the system counters reported by
+// XSyncListSystemCounters for the one named "IDLETIME", returning its
+// counter id or None if not found.
+static XSyncCounter findIdletimeCounter(Display *disp) {
+	int n = 0;
+	XSyncSystemCounter *counters = XSyncListSystemCounters(disp, &n);
+	XSyncCounter found = None;
+	for (int i = 0; i < n; i++) {
+		if (strcmp(counters[i].name, "IDLETIME") == 0) {
+			found = counters[i].counter;
+			break;
+		}
+	}
+	if (counters != NULL) {
+		XSyncFreeSystemCounterList(counters);
+	}
+	return found;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"time"
+)
+
+// xSyncIdleTimerBackend reports idle time using the "IDLETIME" system
+// counter exposed by the XSync extension. Modern desktop environments
+// (GNOME, KDE, and the screen lockers built on them) maintain this
+// counter and it tends to be accurate in situations where the XSS
+// extension reports stale or wrong values, such as inside some VMs and
+// nested/Xwayland displays.
+type xSyncIdleTimerBackend struct {
+	disp    *C.Display
+	counter C.XSyncCounter
+}
+
+// NewXSyncIdleTimerBackend opens its own connection to the named X display
+// (as understood by XOpenDisplay; pass "" for the default, $DISPLAY) and
+// returns a Backend backed by the XSync extension's "IDLETIME" system
+// counter, or an error if the extension or the counter isn't available.
+func NewXSyncIdleTimerBackend(name string) (Backend, error) {
+	disp, err := openDisplay(name)
+	if err != nil {
+		return nil, err
+	}
+	var major, minor C.int
+	if C.XSyncQueryExtension(disp, &major, &minor) == 0 {
+		C.XCloseDisplay(disp)
+		return nil, fmt.Errorf("xss: XSync extension not active")
+	}
+	if C.XSyncInitialize(disp, &major, &minor) == 0 {
+		C.XCloseDisplay(disp)
+		return nil, fmt.Errorf("xss: could not initialize XSync extension")
+	}
+	counter := C.findIdletimeCounter(disp)
+	if counter == C.None {
+		C.XCloseDisplay(disp)
+		return nil, fmt.Errorf("xss: no IDLETIME counter reported by XSync")
+	}
+	return &xSyncIdleTimerBackend{disp: disp, counter: counter}, nil
+}
+
+func (b *xSyncIdleTimerBackend) Query() (i Info, err error) {
+	var value C.XSyncValue
+	if C.XSyncQueryCounter(b.disp, b.counter, &value) == 0 {
+		err = fmt.Errorf("xss: error querying IDLETIME counter")
+		return
+	}
+	ms := int64(C.XSyncValueHigh32(value))<<32 | int64(uint32(C.XSyncValueLow32(value)))
+	i.IdleTime = time.Duration(ms) * time.Millisecond
+	return
+}
+
+func (b *xSyncIdleTimerBackend) Close() error {
+	C.XCloseDisplay(b.disp)
+	return nil
+}
+
+func (b *xSyncIdleTimerBackend) backendDisplay() *C.Display {
+	return b.disp
+}